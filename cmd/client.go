@@ -3,13 +3,17 @@ package main
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"github.com/coreos/go-systemd/daemon"
 	"github.com/lucas-clemente/quic-go"
-	"github.com/lucas-clemente/quic-go/congestion"
+	qcongestion "github.com/lucas-clemente/quic-go/congestion"
 	"github.com/sirupsen/logrus"
 	"github.com/tobyxdd/hysteria/pkg/acl"
 	hyCongestion "github.com/tobyxdd/hysteria/pkg/congestion"
 	"github.com/tobyxdd/hysteria/pkg/core"
 	hyHTTP "github.com/tobyxdd/hysteria/pkg/http"
+	"github.com/tobyxdd/hysteria/pkg/lifecycle"
+	"github.com/tobyxdd/hysteria/pkg/log"
+	"github.com/tobyxdd/hysteria/pkg/metrics"
 	"github.com/tobyxdd/hysteria/pkg/obfs"
 	"github.com/tobyxdd/hysteria/pkg/relay"
 	"github.com/tobyxdd/hysteria/pkg/socks5"
@@ -20,12 +24,28 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 func client(config *clientConfig) {
-	logrus.WithField("config", config.String()).Info("Client configuration loaded")
+	if err := log.ConfigureLogger(config.Log.Level, config.Log.Format); err != nil {
+		log.Log("client").WithField("error", err).Fatal("Invalid log level or format")
+	}
+	for subsystem, level := range config.Log.Levels {
+		if err := log.SetSubsystemLevel(subsystem, level); err != nil {
+			log.Log("client").WithFields(logrus.Fields{
+				"error":     err,
+				"subsystem": subsystem,
+			}).Fatal("Invalid per-subsystem log level")
+		}
+	}
+	if config.Log.File != "" {
+		log.SetFile(config.Log.File, config.Log.FileMaxSizeMB, config.Log.FileMaxBackups, config.Log.FileMaxAgeDays)
+	}
+	log.Log("client").WithField("config", config.String()).Info("Client configuration loaded")
 	// TLS
 	tlsConfig := &tls.Config{
 		ServerName:         config.ServerName,
@@ -41,14 +61,14 @@ func client(config *clientConfig) {
 	if len(config.CustomCA) > 0 {
 		bs, err := ioutil.ReadFile(config.CustomCA)
 		if err != nil {
-			logrus.WithFields(logrus.Fields{
+			log.Log("client").WithFields(logrus.Fields{
 				"error": err,
 				"file":  config.CustomCA,
 			}).Fatal("Failed to load CA")
 		}
 		cp := x509.NewCertPool()
 		if !cp.AppendCertsFromPEM(bs) {
-			logrus.WithFields(logrus.Fields{
+			log.Log("client").WithFields(logrus.Fields{
 				"file": config.CustomCA,
 			}).Fatal("Failed to parse CA")
 		}
@@ -86,27 +106,161 @@ func client(config *clientConfig) {
 	}
 	// ACL
 	var aclEngine *acl.Engine
+	// aclMu guards reloads below against concurrent rule lookups from the
+	// already-running SOCKS5/HTTP servers, both of which hold onto aclEngine
+	// for the lifetime of the process.
+	var aclMu sync.Mutex
 	if len(config.ACL) > 0 {
 		var err error
 		aclEngine, err = acl.LoadFromFile(config.ACL, transport.DefaultTransport)
 		if err != nil {
-			logrus.WithFields(logrus.Fields{
+			log.Log("client").WithFields(logrus.Fields{
 				"error": err,
 				"file":  config.ACL,
 			}).Fatal("Failed to parse ACL")
 		}
 	}
 	// Client
-	client, err := core.NewClient(config.Server, auth, tlsConfig, quicConfig, transport.DefaultTransport,
-		uint64(config.UpMbps)*mbpsToBps, uint64(config.DownMbps)*mbpsToBps,
-		func(refBPS uint64) congestion.CongestionControl {
-			return hyCongestion.NewBrutalSender(congestion.ByteCount(refBPS))
-		}, obfuscator)
+	// Server connection: QUIC by default. transport.type "ws" forces the
+	// WebSocket-over-HTTPS fallback transport; "ws-fallback" tries QUIC
+	// first and only switches to it if the QUIC handshake doesn't complete
+	// within transport.fallback_timeout, for networks that block or
+	// heavily throttle UDP.
+	ccName := config.Congestion
+	if ccName == "" {
+		ccName = "brutal"
+	}
+	baseCongestionFactory, err := hyCongestion.Lookup(ccName)
 	if err != nil {
-		logrus.WithField("error", err).Fatal("Failed to initialize client")
+		log.Log("client").WithField("error", err).Fatal("Invalid congestion control")
+	}
+	// Wrap the factory to stash whatever CongestionControl it builds, so the
+	// poller below can read it for the QUICCongestionWindow/QUICRTT metrics
+	// and the admin status instead of needing core.Client to expose them itself.
+	var ccMu sync.Mutex
+	var activeCC ccWithRTT
+	var lastRTTSeconds float64
+	congestionFactory := func(refBPS uint64) qcongestion.CongestionControl {
+		cc := byteMeteringCC{baseCongestionFactory(refBPS)}
+		ccMu.Lock()
+		activeCC = cc
+		ccMu.Unlock()
+		return cc
+	}
+	dialWS := func() (*core.Client, error) {
+		wsTransport := transport.NewWSTransport(config.Transport.WS.Host, config.Transport.WS.Path, tlsConfig)
+		return core.NewClientWithTransport(config.Server, auth, wsTransport,
+			uint64(config.UpMbps)*mbpsToBps, uint64(config.DownMbps)*mbpsToBps, congestionFactory, obfuscator)
+	}
+
+	var client *core.Client
+	switch config.Transport.Type {
+	case "ws":
+		log.Log("client").Info("Using WebSocket transport")
+		client, err = dialWS()
+	case "ws-fallback":
+		fallbackQUICConfig := *quicConfig
+		if config.Transport.FallbackTimeout > 0 {
+			fallbackQUICConfig.HandshakeIdleTimeout = time.Duration(config.Transport.FallbackTimeout) * time.Second
+		}
+		client, err = core.NewClient(config.Server, auth, tlsConfig, &fallbackQUICConfig, transport.DefaultTransport,
+			uint64(config.UpMbps)*mbpsToBps, uint64(config.DownMbps)*mbpsToBps, congestionFactory, obfuscator)
+		if err != nil {
+			log.Log("client").WithField("error", err).Warn("QUIC handshake failed, falling back to WebSocket transport")
+			metrics.Reconnects.Inc()
+			client, err = dialWS()
+		}
+	default:
+		client, err = core.NewClient(config.Server, auth, tlsConfig, quicConfig, transport.DefaultTransport,
+			uint64(config.UpMbps)*mbpsToBps, uint64(config.DownMbps)*mbpsToBps, congestionFactory, obfuscator)
+	}
+	if err != nil {
+		log.Log("client").WithField("error", err).Fatal("Failed to initialize client")
 	}
 	defer client.Close()
-	logrus.WithField("addr", config.Server).Info("Connected")
+	log.Log("client").WithField("addr", config.Server).Info("Connected")
+
+	// systemd readiness & watchdog notifications (no-op if not running under systemd)
+	sdNotify(daemon.SdNotifyReady)
+	defer sdNotify(daemon.SdNotifyStopping)
+	if interval, err := daemon.SdWatchdogEnabled(false); err == nil && interval > 0 {
+		go func() {
+			for range time.Tick(interval / 2) {
+				sdNotify(daemon.SdNotifyWatchdog)
+			}
+		}()
+	}
+
+	// Graceful reload (SIGHUP/SIGUSR2) and graceful shutdown (SIGTERM/SIGINT)
+	lifecycleMgr := lifecycle.NewManager(time.Duration(config.DrainTimeout) * time.Second)
+	lifecycleMgr.OnReload = func() { sdNotify(daemon.SdNotifyReloading) }
+	lifecycleMgr.OnShutdown = func() { sdNotify(daemon.SdNotifyStopping) }
+	go lifecycleMgr.Run()
+
+	// Periodically pull QUIC RTT/congestion window off the active congestion
+	// controller. RTT isn't part of the qcongestion.CongestionControl
+	// interface itself, so this only reports it for senders (like BBRSender)
+	// that choose to expose one; for others the RTT is reported as 0.
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			ccMu.Lock()
+			cc := activeCC
+			ccMu.Unlock()
+			if cc == nil {
+				continue
+			}
+			metrics.QUICCongestionWindow.Set(float64(cc.GetCongestionWindow()))
+			rtt := cc.RTT().Seconds()
+			metrics.QUICRTT.Set(rtt)
+			ccMu.Lock()
+			lastRTTSeconds = rtt
+			ccMu.Unlock()
+		}
+	}()
+
+	// Admin (Prometheus metrics + control API)
+	if len(config.Admin.Listen) > 0 {
+		go func() {
+			admin := metrics.NewAdminServer()
+			admin.StatusFunc = func() metrics.Status {
+				ccMu.Lock()
+				rtt := lastRTTSeconds
+				ccMu.Unlock()
+				return metrics.Status{
+					Server:    config.Server,
+					Connected: true,
+					QUICRTT:   rtt,
+				}
+			}
+			admin.ReloadACLFunc = func() error {
+				if len(config.ACL) == 0 {
+					return nil
+				}
+				newACL, err := acl.LoadFromFile(config.ACL, transport.DefaultTransport)
+				if err != nil {
+					return err
+				}
+				// socks5.NewServerFromListener/hyHTTP.NewProxyHTTPServer were
+				// already handed the *acl.Engine below by value, so reassigning
+				// the local aclEngine variable here wouldn't do anything: the
+				// running servers would keep the pointer they were constructed
+				// with. Overwrite what it points to instead, so they see the
+				// new rules on their very next lookup.
+				aclMu.Lock()
+				*aclEngine = *newACL
+				aclMu.Unlock()
+				log.Log("admin").WithField("file", config.ACL).Info("ACL reloaded")
+				return nil
+			}
+			admin.SetListenerEnabledFunc = lifecycleMgr.SetEnabled
+			log.Log("admin").WithField("addr", config.Admin.Listen).Info("Admin server up and running")
+			if err := admin.ListenAndServe(config.Admin.Listen); err != nil {
+				log.Log("admin").WithField("error", err).Error("Admin server error")
+			}
+		}()
+	}
 
 	// Local
 	errChan := make(chan error)
@@ -118,50 +272,67 @@ func client(config *clientConfig) {
 					return config.SOCKS5.User == user && config.SOCKS5.Password == password
 				}
 			}
-			socks5server, err := socks5.NewServer(client, transport.DefaultTransport, config.SOCKS5.Listen, authFunc,
-				time.Duration(config.SOCKS5.Timeout)*time.Second, aclEngine, config.SOCKS5.DisableUDP,
-				func(addr net.Addr, reqAddr string, action acl.Action, arg string) {
-					logrus.WithFields(logrus.Fields{
-						"action": actionToString(action, arg),
-						"src":    addr.String(),
-						"dst":    reqAddr,
-					}).Debug("SOCKS5 TCP request")
-				},
-				func(addr net.Addr, reqAddr string, err error) {
-					if err != io.EOF {
-						logrus.WithFields(logrus.Fields{
-							"error": err,
-							"src":   addr.String(),
-							"dst":   reqAddr,
-						}).Info("SOCKS5 TCP error")
-					} else {
-						logrus.WithFields(logrus.Fields{
-							"src": addr.String(),
-							"dst": reqAddr,
-						}).Debug("SOCKS5 TCP EOF")
-					}
-				},
-				func(addr net.Addr) {
-					logrus.WithFields(logrus.Fields{
+			tcpRequestFunc := func(addr net.Addr, reqAddr string, action acl.Action, arg string) {
+				metrics.Requests.WithLabelValues(metrics.ListenerSOCKS5).Inc()
+				metrics.ACLActions.WithLabelValues(actionToString(action, arg)).Inc()
+				metrics.IncActiveSessions(metrics.ListenerSOCKS5, 1)
+				log.Log("socks5").WithFields(logrus.Fields{
+					"action": actionToString(action, arg),
+					"src":    addr.String(),
+					"dst":    reqAddr,
+				}).Debug("SOCKS5 TCP request")
+			}
+			tcpErrorFunc := func(addr net.Addr, reqAddr string, err error) {
+				metrics.IncActiveSessions(metrics.ListenerSOCKS5, -1)
+				if err != io.EOF {
+					log.Log("socks5").WithFields(logrus.Fields{
+						"error": err,
+						"src":   addr.String(),
+						"dst":   reqAddr,
+					}).Info("SOCKS5 TCP error")
+				} else {
+					log.Log("socks5").WithFields(logrus.Fields{
 						"src": addr.String(),
-					}).Debug("SOCKS5 UDP associate")
-				},
-				func(addr net.Addr, err error) {
-					if err != io.EOF {
-						logrus.WithFields(logrus.Fields{
-							"error": err,
-							"src":   addr.String(),
-						}).Info("SOCKS5 UDP error")
-					} else {
-						logrus.WithFields(logrus.Fields{
-							"src": addr.String(),
-						}).Debug("SOCKS5 UDP EOF")
-					}
-				})
+						"dst": reqAddr,
+					}).Debug("SOCKS5 TCP EOF")
+				}
+			}
+			udpAssociateFunc := func(addr net.Addr) {
+				metrics.IncActiveSessions(metrics.ListenerSOCKS5, 1)
+				log.Log("socks5").WithFields(logrus.Fields{
+					"src": addr.String(),
+				}).Debug("SOCKS5 UDP associate")
+			}
+			udpErrorFunc := func(addr net.Addr, err error) {
+				metrics.IncActiveSessions(metrics.ListenerSOCKS5, -1)
+				if err != io.EOF {
+					log.Log("socks5").WithFields(logrus.Fields{
+						"error": err,
+						"src":   addr.String(),
+					}).Info("SOCKS5 UDP error")
+				} else {
+					log.Log("socks5").WithFields(logrus.Fields{
+						"src": addr.String(),
+					}).Debug("SOCKS5 UDP EOF")
+				}
+			}
+			l, err := openOrInheritListener("hysteria-socks5", "tcp", config.SOCKS5.Listen)
 			if err != nil {
-				logrus.WithField("error", err).Fatal("Failed to initialize SOCKS5 server")
+				log.Log("socks5").WithFields(logrus.Fields{
+					"error": err,
+					"addr":  config.SOCKS5.Listen,
+				}).Fatal("Failed to listen on SOCKS5 address")
 			}
-			logrus.WithField("addr", config.SOCKS5.Listen).Info("SOCKS5 server up and running")
+			socks5server, err := socks5.NewServerFromListener(l, client, transport.DefaultTransport, authFunc,
+				time.Duration(config.SOCKS5.Timeout)*time.Second, aclEngine, config.SOCKS5.DisableUDP,
+				tcpRequestFunc, tcpErrorFunc, udpAssociateFunc, udpErrorFunc)
+			if err != nil {
+				log.Log("socks5").WithField("error", err).Fatal("Failed to initialize SOCKS5 server")
+			}
+			if err := lifecycleMgr.RegisterListener("hysteria-socks5", l, socks5server); err != nil {
+				log.Log("socks5").WithField("error", err).Warn("SOCKS5 listener won't survive a reload")
+			}
+			log.Log("socks5").WithField("addr", config.SOCKS5.Listen).Info("SOCKS5 server up and running")
 			errChan <- socks5server.ListenAndServe()
 		}()
 	}
@@ -177,22 +348,40 @@ func client(config *clientConfig) {
 			proxy, err := hyHTTP.NewProxyHTTPServer(client, transport.DefaultTransport,
 				time.Duration(config.HTTP.Timeout)*time.Second, aclEngine,
 				func(reqAddr string, action acl.Action, arg string) {
-					logrus.WithFields(logrus.Fields{
+					metrics.Requests.WithLabelValues(metrics.ListenerHTTP).Inc()
+					metrics.ACLActions.WithLabelValues(actionToString(action, arg)).Inc()
+					log.Log("http").WithFields(logrus.Fields{
 						"action": actionToString(action, arg),
 						"dst":    reqAddr,
 					}).Debug("HTTP request")
 				},
 				authFunc)
 			if err != nil {
-				logrus.WithField("error", err).Fatal("Failed to initialize HTTP server")
+				log.Log("http").WithField("error", err).Fatal("Failed to initialize HTTP server")
 			}
+			l, err := openOrInheritListener("hysteria-http", "tcp", config.HTTP.Listen)
+			if err != nil {
+				log.Log("http").WithFields(logrus.Fields{
+					"error": err,
+					"addr":  config.HTTP.Listen,
+				}).Fatal("Failed to listen on HTTP address")
+			}
+			httpServer := &http.Server{Handler: proxy}
+			if err := lifecycleMgr.RegisterListener("hysteria-http", l, httpServer); err != nil {
+				log.Log("http").WithField("error", err).Warn("HTTP listener won't survive a reload")
+			}
+			serveListener := l
 			if config.HTTP.Cert != "" && config.HTTP.Key != "" {
-				logrus.WithField("addr", config.HTTP.Listen).Info("HTTPS server up and running")
-				errChan <- http.ListenAndServeTLS(config.HTTP.Listen, config.HTTP.Cert, config.HTTP.Key, proxy)
+				cert, err := tls.LoadX509KeyPair(config.HTTP.Cert, config.HTTP.Key)
+				if err != nil {
+					log.Log("http").WithField("error", err).Fatal("Failed to load HTTPS certificate")
+				}
+				serveListener = tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{cert}})
+				log.Log("http").WithField("addr", config.HTTP.Listen).Info("HTTPS server up and running")
 			} else {
-				logrus.WithField("addr", config.HTTP.Listen).Info("HTTP server up and running")
-				errChan <- http.ListenAndServe(config.HTTP.Listen, proxy)
+				log.Log("http").WithField("addr", config.HTTP.Listen).Info("HTTP server up and running")
 			}
+			errChan <- httpServer.Serve(serveListener)
 		}()
 	}
 
@@ -206,33 +395,36 @@ func client(config *clientConfig) {
 				time.Duration(config.TUN.Timeout)*time.Second,
 				config.TUN.Name, config.TUN.Address, config.TUN.Gateway, config.TUN.Mask, config.TUN.DNS, config.TUN.Persist)
 			if err != nil {
-				logrus.WithField("error", err).Fatal("Failed to initialize TUN server")
+				log.Log("tun").WithField("error", err).Fatal("Failed to initialize TUN server")
 			}
 			tunServer.RequestFunc = func(addr net.Addr, reqAddr string) {
-				logrus.WithFields(logrus.Fields{
+				metrics.Requests.WithLabelValues(metrics.ListenerTUN).Inc()
+				metrics.IncActiveSessions(metrics.ListenerTUN, 1)
+				log.Log("tun").WithFields(logrus.Fields{
 					"src": addr.String(),
 					"dst": reqAddr,
 				}).Debugf("TUN %s request", strings.ToUpper(addr.Network()))
 			}
 			tunServer.ErrorFunc = func(addr net.Addr, reqAddr string, err error) {
+				metrics.IncActiveSessions(metrics.ListenerTUN, -1)
 				if err != nil {
 					if err == io.EOF {
-						logrus.WithFields(logrus.Fields{
+						log.Log("tun").WithFields(logrus.Fields{
 							"src": addr.String(),
 							"dst": reqAddr,
 						}).Debugf("TUN %s EOF", strings.ToUpper(addr.Network()))
 					} else if err == core.ErrClosed && strings.HasPrefix(addr.Network(), "udp") {
-						logrus.WithFields(logrus.Fields{
+						log.Log("tun").WithFields(logrus.Fields{
 							"src": addr.String(),
 							"dst": reqAddr,
 						}).Debugf("TUN %s closed for timeout", strings.ToUpper(addr.Network()))
 					} else if err.Error() == "deadline exceeded" && strings.HasPrefix(addr.Network(), "tcp") {
-						logrus.WithFields(logrus.Fields{
+						log.Log("tun").WithFields(logrus.Fields{
 							"src": addr.String(),
 							"dst": reqAddr,
 						}).Debugf("TUN %s closed for timeout", strings.ToUpper(addr.Network()))
 					} else {
-						logrus.WithFields(logrus.Fields{
+						log.Log("tun").WithFields(logrus.Fields{
 							"error": err,
 							"src":   addr.String(),
 							"dst":   reqAddr,
@@ -240,131 +432,240 @@ func client(config *clientConfig) {
 					}
 				}
 			}
+			if f, ok := interface{}(tunServer).(interface{ File() (*os.File, error) }); ok {
+				if file, err := f.File(); err != nil {
+					log.Log("tun").WithField("error", err).Warn("TUN device does not support fd handoff, interface will be recreated on reload")
+					lifecycleMgr.RegisterCloser("hysteria-tun", tunServer)
+				} else {
+					lifecycleMgr.RegisterFile("hysteria-tun", file, tunServer)
+				}
+			} else {
+				log.Log("tun").Warn("TUN device does not support fd handoff, interface will be recreated on reload")
+				lifecycleMgr.RegisterCloser("hysteria-tun", tunServer)
+			}
 			errChan <- tunServer.ListenAndServe()
 		}()
 	}
 
 	if len(config.TCPRelay.Listen) > 0 {
 		go func() {
-			rl, err := relay.NewTCPRelay(client, transport.DefaultTransport,
-				config.TCPRelay.Listen, config.TCPRelay.Remote,
-				time.Duration(config.TCPRelay.Timeout)*time.Second,
-				func(addr net.Addr) {
-					logrus.WithFields(logrus.Fields{
+			requestFunc := func(addr net.Addr) {
+				metrics.Requests.WithLabelValues(metrics.ListenerTCP).Inc()
+				metrics.IncActiveSessions(metrics.ListenerTCP, 1)
+				log.Log("tcprelay").WithFields(logrus.Fields{
+					"src": addr.String(),
+				}).Debug("TCP relay request")
+			}
+			errorFunc := func(addr net.Addr, err error) {
+				metrics.IncActiveSessions(metrics.ListenerTCP, -1)
+				if err != io.EOF {
+					log.Log("tcprelay").WithFields(logrus.Fields{
+						"error": err,
+						"src":   addr.String(),
+					}).Info("TCP relay error")
+				} else {
+					log.Log("tcprelay").WithFields(logrus.Fields{
 						"src": addr.String(),
-					}).Debug("TCP relay request")
-				},
-				func(addr net.Addr, err error) {
-					if err != io.EOF {
-						logrus.WithFields(logrus.Fields{
-							"error": err,
-							"src":   addr.String(),
-						}).Info("TCP relay error")
-					} else {
-						logrus.WithFields(logrus.Fields{
-							"src": addr.String(),
-						}).Debug("TCP relay EOF")
-					}
-				})
+					}).Debug("TCP relay EOF")
+				}
+			}
+			l, err := openOrInheritListener("hysteria-tcprelay", "tcp", config.TCPRelay.Listen)
+			if err != nil {
+				log.Log("tcprelay").WithFields(logrus.Fields{
+					"error": err,
+					"addr":  config.TCPRelay.Listen,
+				}).Fatal("Failed to listen on TCP relay address")
+			}
+			rl, err := relay.NewTCPRelayFromListener(l, client, transport.DefaultTransport,
+				config.TCPRelay.Remote, time.Duration(config.TCPRelay.Timeout)*time.Second,
+				requestFunc, errorFunc)
 			if err != nil {
-				logrus.WithField("error", err).Fatal("Failed to initialize TCP relay")
+				log.Log("tcprelay").WithField("error", err).Fatal("Failed to initialize TCP relay")
+			}
+			if err := lifecycleMgr.RegisterListener("hysteria-tcprelay", l, rl); err != nil {
+				log.Log("tcprelay").WithField("error", err).Warn("TCP relay listener won't survive a reload")
 			}
-			logrus.WithField("addr", config.TCPRelay.Listen).Info("TCP relay up and running")
+			log.Log("tcprelay").WithField("addr", config.TCPRelay.Listen).Info("TCP relay up and running")
 			errChan <- rl.ListenAndServe()
 		}()
 	}
 
 	if len(config.UDPRelay.Listen) > 0 {
 		go func() {
-			rl, err := relay.NewUDPRelay(client, transport.DefaultTransport,
-				config.UDPRelay.Listen, config.UDPRelay.Remote,
-				time.Duration(config.UDPRelay.Timeout)*time.Second,
-				func(addr net.Addr) {
-					logrus.WithFields(logrus.Fields{
+			requestFunc := func(addr net.Addr) {
+				metrics.Requests.WithLabelValues(metrics.ListenerUDP).Inc()
+				metrics.IncActiveSessions(metrics.ListenerUDP, 1)
+				log.Log("udprelay").WithFields(logrus.Fields{
+					"src": addr.String(),
+				}).Debug("UDP relay request")
+			}
+			errorFunc := func(addr net.Addr, err error) {
+				metrics.IncActiveSessions(metrics.ListenerUDP, -1)
+				if err != relay.ErrTimeout {
+					log.Log("udprelay").WithFields(logrus.Fields{
+						"error": err,
+						"src":   addr.String(),
+					}).Info("UDP relay error")
+				} else {
+					log.Log("udprelay").WithFields(logrus.Fields{
 						"src": addr.String(),
-					}).Debug("UDP relay request")
-				},
-				func(addr net.Addr, err error) {
-					if err != relay.ErrTimeout {
-						logrus.WithFields(logrus.Fields{
-							"error": err,
-							"src":   addr.String(),
-						}).Info("UDP relay error")
-					} else {
-						logrus.WithFields(logrus.Fields{
-							"src": addr.String(),
-						}).Debug("UDP relay session closed")
-					}
-				})
+					}).Debug("UDP relay session closed")
+				}
+			}
+			pc, err := openOrInheritPacketConn("hysteria-udprelay", "udp", config.UDPRelay.Listen)
+			if err != nil {
+				log.Log("udprelay").WithFields(logrus.Fields{
+					"error": err,
+					"addr":  config.UDPRelay.Listen,
+				}).Fatal("Failed to listen on UDP relay address")
+			}
+			rl, err := relay.NewUDPRelayFromPacketConn(pc, client, transport.DefaultTransport,
+				config.UDPRelay.Remote, time.Duration(config.UDPRelay.Timeout)*time.Second,
+				requestFunc, errorFunc)
 			if err != nil {
-				logrus.WithField("error", err).Fatal("Failed to initialize UDP relay")
+				log.Log("udprelay").WithField("error", err).Fatal("Failed to initialize UDP relay")
+			}
+			if err := lifecycleMgr.RegisterPacketConn("hysteria-udprelay", pc, rl); err != nil {
+				log.Log("udprelay").WithField("error", err).Warn("UDP relay listener won't survive a reload")
 			}
-			logrus.WithField("addr", config.UDPRelay.Listen).Info("UDP relay up and running")
+			log.Log("udprelay").WithField("addr", config.UDPRelay.Listen).Info("UDP relay up and running")
 			errChan <- rl.ListenAndServe()
 		}()
 	}
 
 	if len(config.TCPTProxy.Listen) > 0 {
 		go func() {
-			rl, err := tproxy.NewTCPTProxy(client, transport.DefaultTransport,
-				config.TCPTProxy.Listen, time.Duration(config.TCPTProxy.Timeout)*time.Second,
-				func(addr, reqAddr net.Addr) {
-					logrus.WithFields(logrus.Fields{
+			requestFunc := func(addr, reqAddr net.Addr) {
+				metrics.Requests.WithLabelValues(metrics.ListenerTProxy).Inc()
+				metrics.IncActiveSessions(metrics.ListenerTProxy, 1)
+				log.Log("tcptproxy").WithFields(logrus.Fields{
+					"src": addr.String(),
+					"dst": reqAddr.String(),
+				}).Debug("TCP TProxy request")
+			}
+			errorFunc := func(addr, reqAddr net.Addr, err error) {
+				metrics.IncActiveSessions(metrics.ListenerTProxy, -1)
+				if err != io.EOF {
+					log.Log("tcptproxy").WithFields(logrus.Fields{
+						"error": err,
+						"src":   addr.String(),
+						"dst":   reqAddr.String(),
+					}).Info("TCP TProxy error")
+				} else {
+					log.Log("tcptproxy").WithFields(logrus.Fields{
 						"src": addr.String(),
 						"dst": reqAddr.String(),
-					}).Debug("TCP TProxy request")
-				},
-				func(addr, reqAddr net.Addr, err error) {
-					if err != io.EOF {
-						logrus.WithFields(logrus.Fields{
-							"error": err,
-							"src":   addr.String(),
-							"dst":   reqAddr.String(),
-						}).Info("TCP TProxy error")
-					} else {
-						logrus.WithFields(logrus.Fields{
-							"src": addr.String(),
-							"dst": reqAddr.String(),
-						}).Debug("TCP TProxy EOF")
-					}
-				})
+					}).Debug("TCP TProxy EOF")
+				}
+			}
+			// TPROXY sockets need IP_TRANSPARENT set at listen time, which only
+			// tproxy.NewTCPTProxy knows how to do, so we only skip it in favor of
+			// an inherited listener (e.g. a systemd .socket with TransparentProxy=true).
+			var rl *tproxy.TCPTProxy
+			var err error
+			var tproxyListener net.Listener
+			if l, ok := systemdListener("hysteria-tcptproxy"); ok {
+				tproxyListener = l
+				rl, err = tproxy.NewTCPTProxyFromListener(l, client, transport.DefaultTransport,
+					time.Duration(config.TCPTProxy.Timeout)*time.Second, requestFunc, errorFunc)
+			} else {
+				rl, err = tproxy.NewTCPTProxy(client, transport.DefaultTransport,
+					config.TCPTProxy.Listen, time.Duration(config.TCPTProxy.Timeout)*time.Second,
+					requestFunc, errorFunc)
+			}
 			if err != nil {
-				logrus.WithField("error", err).Fatal("Failed to initialize TCP TProxy")
+				log.Log("tcptproxy").WithField("error", err).Fatal("Failed to initialize TCP TProxy")
 			}
-			logrus.WithField("addr", config.TCPTProxy.Listen).Info("TCP TProxy up and running")
+			if tproxyListener != nil {
+				if err := lifecycleMgr.RegisterListener("hysteria-tcptproxy", tproxyListener, rl); err != nil {
+					log.Log("tcptproxy").WithField("error", err).Warn("TCP TProxy listener won't survive a reload")
+				}
+			}
+			log.Log("tcptproxy").WithField("addr", config.TCPTProxy.Listen).Info("TCP TProxy up and running")
 			errChan <- rl.ListenAndServe()
 		}()
 	}
 
 	if len(config.UDPTProxy.Listen) > 0 {
 		go func() {
-			rl, err := tproxy.NewUDPTProxy(client, transport.DefaultTransport,
-				config.UDPTProxy.Listen, time.Duration(config.UDPTProxy.Timeout)*time.Second,
-				func(addr net.Addr) {
-					logrus.WithFields(logrus.Fields{
+			requestFunc := func(addr net.Addr) {
+				metrics.Requests.WithLabelValues(metrics.ListenerTProxy).Inc()
+				metrics.IncActiveSessions(metrics.ListenerTProxy, 1)
+				log.Log("udptproxy").WithFields(logrus.Fields{
+					"src": addr.String(),
+				}).Debug("UDP TProxy request")
+			}
+			errorFunc := func(addr net.Addr, err error) {
+				metrics.IncActiveSessions(metrics.ListenerTProxy, -1)
+				if err != tproxy.ErrTimeout {
+					log.Log("udptproxy").WithFields(logrus.Fields{
+						"error": err,
+						"src":   addr.String(),
+					}).Info("UDP TProxy error")
+				} else {
+					log.Log("udptproxy").WithFields(logrus.Fields{
 						"src": addr.String(),
-					}).Debug("UDP TProxy request")
-				},
-				func(addr net.Addr, err error) {
-					if err != tproxy.ErrTimeout {
-						logrus.WithFields(logrus.Fields{
-							"error": err,
-							"src":   addr.String(),
-						}).Info("UDP TProxy error")
-					} else {
-						logrus.WithFields(logrus.Fields{
-							"src": addr.String(),
-						}).Debug("UDP TProxy session closed")
-					}
-				})
+					}).Debug("UDP TProxy session closed")
+				}
+			}
+			var rl *tproxy.UDPTProxy
+			var err error
+			var tproxyPacketConn net.PacketConn
+			if pc, ok := systemdPacketConn("hysteria-udptproxy"); ok {
+				tproxyPacketConn = pc
+				rl, err = tproxy.NewUDPTProxyFromPacketConn(pc, client, transport.DefaultTransport,
+					time.Duration(config.UDPTProxy.Timeout)*time.Second, requestFunc, errorFunc)
+			} else {
+				rl, err = tproxy.NewUDPTProxy(client, transport.DefaultTransport,
+					config.UDPTProxy.Listen, time.Duration(config.UDPTProxy.Timeout)*time.Second,
+					requestFunc, errorFunc)
+			}
 			if err != nil {
-				logrus.WithField("error", err).Fatal("Failed to initialize UDP TProxy")
+				log.Log("udptproxy").WithField("error", err).Fatal("Failed to initialize UDP TProxy")
+			}
+			if tproxyPacketConn != nil {
+				if err := lifecycleMgr.RegisterPacketConn("hysteria-udptproxy", tproxyPacketConn, rl); err != nil {
+					log.Log("udptproxy").WithField("error", err).Warn("UDP TProxy listener won't survive a reload")
+				}
 			}
-			logrus.WithField("addr", config.UDPTProxy.Listen).Info("UDP TProxy up and running")
+			log.Log("udptproxy").WithField("addr", config.UDPTProxy.Listen).Info("UDP TProxy up and running")
 			errChan <- rl.ListenAndServe()
 		}()
 	}
 
 	err = <-errChan
-	logrus.WithField("error", err).Fatal("Client shutdown")
+	log.Log("client").WithField("error", err).Fatal("Client shutdown")
+}
+
+// ccWithRTT is implemented by congestion controllers (like BBRSender) that
+// expose their current RTT estimate, for the QUICRTT metric/admin status
+// below. Controllers that don't (Brutal, Cubic, Reno) just report 0.
+type ccWithRTT interface {
+	qcongestion.CongestionControl
+	RTT() time.Duration
+}
+
+// byteMeteringCC wraps a qcongestion.CongestionControl to additionally feed
+// metrics.BytesTransferred on every packet sent; every other method is
+// forwarded straight through via the embedded interface. There's no
+// equivalent hook for download bytes: CongestionControl only observes the
+// send path (OnPacketSent/OnPacketAcked/OnPacketLost), never the receive one.
+type byteMeteringCC struct {
+	qcongestion.CongestionControl
+}
+
+func (c byteMeteringCC) OnPacketSent(sentTime time.Time, bytesInFlight qcongestion.ByteCount,
+	packetNumber qcongestion.PacketNumber, bytes qcongestion.ByteCount, isRetransmittable bool) {
+	metrics.AddBytes(metrics.ListenerAggregate, "tx", int(bytes))
+	c.CongestionControl.OnPacketSent(sentTime, bytesInFlight, packetNumber, bytes, isRetransmittable)
+}
+
+// RTT forwards to the wrapped controller's RTT if it has one, so wrapping
+// with byteMeteringCC doesn't hide it behind the embedded interface's
+// narrower method set.
+func (c byteMeteringCC) RTT() time.Duration {
+	if r, ok := c.CongestionControl.(interface{ RTT() time.Duration }); ok {
+		return r.RTT()
+	}
+	return 0
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-systemd/activation"
+	"github.com/coreos/go-systemd/daemon"
+	"github.com/sirupsen/logrus"
+)
+
+// Named file descriptors passed in by a systemd socket-activated unit, keyed
+// by the entry's FileDescriptorName (see systemd.socket(5)). Populated once
+// at startup from LISTEN_FDS/LISTEN_FDNAMES via the go-systemd activation
+// package, so a restarted unit doesn't drop its listening ports.
+var (
+	systemdListeners   = map[string]net.Listener{}
+	systemdPacketConns = map[string]net.PacketConn{}
+)
+
+func init() {
+	// activation.Files only hands back fds when LISTEN_PID == os.Getpid().
+	// That's correct for systemd itself, which forks us and can set
+	// LISTEN_PID to our real pid before the exec; it can't work the same way
+	// for lifecycle.Manager's self-reexec, since the parent there has no way
+	// to know our pid before we exist to have one. Normalize it to our own
+	// pid instead: a no-op when systemd already got it right, and exactly
+	// what the reexec case needs to make the handoff actually take effect.
+	if os.Getenv("LISTEN_FDS") != "" {
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	}
+	files := activation.Files(true)
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i, f := range files {
+		name := f.Name()
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		if l, err := net.FileListener(f); err == nil {
+			systemdListeners[name] = l
+			continue
+		}
+		if pc, err := net.FilePacketConn(f); err == nil {
+			systemdPacketConns[name] = pc
+		}
+	}
+	if len(systemdListeners)+len(systemdPacketConns) > 0 {
+		logrus.WithField("count", len(systemdListeners)+len(systemdPacketConns)).
+			Info("Inherited listening sockets from systemd")
+	}
+}
+
+// systemdListener returns the pre-opened net.Listener named name, if the
+// process was socket-activated with a matching FileDescriptorName.
+func systemdListener(name string) (net.Listener, bool) {
+	l, ok := systemdListeners[name]
+	return l, ok
+}
+
+// systemdPacketConn returns the pre-opened net.PacketConn named name, if the
+// process was socket-activated with a matching FileDescriptorName.
+func systemdPacketConn(name string) (net.PacketConn, bool) {
+	pc, ok := systemdPacketConns[name]
+	return pc, ok
+}
+
+// sdNotify sends a systemd notify-socket state update, e.g. "READY=1" or
+// "STOPPING=1". It's a no-op outside of a Type=notify unit.
+func sdNotify(state string) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		logrus.WithField("error", err).Debug("sd_notify failed")
+	}
+}
+
+// openOrInheritListener returns the listener named name inherited from
+// systemd/a prior instance of this process, or opens a fresh one on addr if
+// there isn't one. Either way the returned listener can be handed off again
+// on the next reload.
+func openOrInheritListener(name, network, addr string) (net.Listener, error) {
+	if l, ok := systemdListener(name); ok {
+		return l, nil
+	}
+	return net.Listen(network, addr)
+}
+
+// openOrInheritPacketConn is openOrInheritListener for the packet-oriented
+// (UDP) listeners.
+func openOrInheritPacketConn(name, network, addr string) (net.PacketConn, error) {
+	if pc, ok := systemdPacketConn(name); ok {
+		return pc, nil
+	}
+	return net.ListenPacket(network, addr)
+}
@@ -0,0 +1,397 @@
+package congestion
+
+import (
+	"time"
+
+	qcongestion "github.com/lucas-clemente/quic-go/congestion"
+)
+
+// bbrMode is one of the four BBRv2 phases described in the BBR Internet
+// Draft: Startup probes for the link's bottleneck bandwidth with an
+// aggressive pacing gain, Drain works off the resulting queue, ProbeBW
+// cycles the pacing gain to periodically probe for more bandwidth while
+// otherwise sending at the estimated rate, and ProbeRTT periodically shrinks
+// the window to get an unqueued min-RTT sample.
+type bbrMode int
+
+const (
+	bbrStartup bbrMode = iota
+	bbrDrain
+	bbrProbeBW
+	bbrProbeRTT
+)
+
+const (
+	// bbrHighGain is 2/ln(2): the pacing/cwnd gain used during Startup so
+	// the window doubles every round trip, same as TCP slow start.
+	bbrHighGain = 2.885
+	// bbrDrainGain is the inverse of bbrHighGain, used during Drain to work
+	// off the queue Startup built up before settling into ProbeBW.
+	bbrDrainGain = 1 / bbrHighGain
+
+	// minRTTExpiry bounds how long a min-RTT sample is trusted before
+	// ProbeRTT is triggered again to get a fresh one.
+	minRTTExpiry = 10 * time.Second
+	// probeRTTDuration is the minimum time spent in ProbeRTT once entered.
+	probeRTTDuration = 200 * time.Millisecond
+
+	// bandwidthWindowRTTs is how many round trips the bandwidth filter
+	// keeps a sample for before it ages out, whatever its size.
+	bandwidthWindowRTTs = 10
+	// minCwndPackets is the floor applied to the congestion window,
+	// expressed in multiples of the max datagram size, including in
+	// ProbeRTT where BBR intentionally shrinks the window the most.
+	minCwndPackets = 4
+	// startupFullBWRounds is how many consecutive rounds of less than
+	// startupFullBWThreshold bandwidth growth mean the pipe is full and
+	// Startup should hand off to Drain.
+	startupFullBWRounds    = 3
+	startupFullBWThreshold = 1.25
+)
+
+// bandwidthSample is one windowed-max-filter entry: a delivery rate
+// estimate, tagged with the round trip it was taken in so older, smaller
+// samples can be evicted once they fall out of the window.
+type bandwidthSample struct {
+	bandwidth qcongestion.ByteCount // bytes/sec
+	round     uint64
+}
+
+// BBRSender is a from-scratch BBRv2-style congestion controller: it tracks a
+// windowed-max delivery rate itself, takes min-RTT from the connection's
+// shared RTTStats, and paces/sizes the congestion window off those
+// estimates instead of reacting to loss the way Cubic/Reno do.
+type BBRSender struct {
+	maxDatagramSize qcongestion.ByteCount
+
+	mode bbrMode
+
+	// rttStats is the shared RTT tracker fed by quic-go's packet handler
+	// from actual send/ack timestamps; BBR only reads MinRTT()/SmoothedRTT()
+	// off it rather than trying to derive an RTT from ack arrival times
+	// itself, the same way the Cubic/Reno factories in registry.go are
+	// each given their own RTTStats.
+	rttStats *qcongestion.RTTStats
+
+	// minRTTStamp/lastMinRTT track when MinRTT() last got smaller, so
+	// maybeEnterProbeRTT knows how long the current estimate has been
+	// trusted without re-deriving that from rttStats itself.
+	minRTTStamp time.Time
+	lastMinRTT  time.Duration
+
+	bwSamples    []bandwidthSample
+	maxBandwidth qcongestion.ByteCount
+
+	pacingGain float64
+	cwndGain   float64
+	cwnd       qcongestion.ByteCount
+	minCwnd    qcongestion.ByteCount
+
+	roundTripCount   uint64
+	totalSent        qcongestion.ByteCount // cumulative bytes sent, used to mark each round's end point
+	roundTripEndedAt qcongestion.ByteCount // round ends once cumulativeAcked reaches this
+	cumulativeAcked  qcongestion.ByteCount
+
+	cycleIndex int
+	cycleStart time.Time
+
+	probeRTTStart  time.Time
+	probeRTTEndsAt time.Time
+
+	fullBandwidthReached bool
+	fullBandwidth        qcongestion.ByteCount
+	fullBandwidthRounds  int
+
+	lastSampleTime  time.Time
+	lastSampleAcked qcongestion.ByteCount
+}
+
+var probeBWGainCycle = [8]float64{1.25, 0.75, 1, 1, 1, 1, 1, 1}
+
+// NewBBRSender creates a BBR congestion controller. initialBandwidth seeds
+// the bandwidth filter so the first Startup round doesn't have to guess;
+// rttStats is the RTT tracker shared with the rest of the quic-go
+// connection, the source of truth for MinRTT().
+func NewBBRSender(initialBandwidth qcongestion.ByteCount, rttStats *qcongestion.RTTStats) *BBRSender {
+	b := &BBRSender{
+		maxDatagramSize: initialMaxDatagramSize,
+		mode:            bbrStartup,
+		rttStats:        rttStats,
+		maxBandwidth:    initialBandwidth,
+		pacingGain:      bbrHighGain,
+		cwndGain:        bbrHighGain,
+		minCwnd:         minCwndPackets * initialMaxDatagramSize,
+	}
+	b.cwnd = b.minCwnd
+	return b
+}
+
+// minRTT is the current trusted round-trip-time estimate, read straight off
+// rttStats.
+func (b *BBRSender) minRTT() time.Duration {
+	if b.rttStats == nil {
+		return 0
+	}
+	return b.rttStats.MinRTT()
+}
+
+// RTT returns the connection's current smoothed round-trip time, for
+// reporting purposes (e.g. pkg/metrics's QUICRTT gauge).
+func (b *BBRSender) RTT() time.Duration {
+	if b.rttStats == nil {
+		return 0
+	}
+	return b.rttStats.SmoothedRTT()
+}
+
+func (b *BBRSender) SetMaxDatagramSize(size qcongestion.ByteCount) {
+	b.maxDatagramSize = size
+	b.minCwnd = minCwndPackets * size
+	if b.cwnd < b.minCwnd {
+		b.cwnd = b.minCwnd
+	}
+}
+
+// bandwidthEstimate is the current windowed-max delivery rate, used to size
+// the window in every mode and by TimeUntilSend once the window is full.
+func (b *BBRSender) bandwidthEstimate() qcongestion.ByteCount {
+	if b.maxBandwidth == 0 {
+		return b.maxDatagramSize * 100 // arbitrary, small bootstrap rate until the first sample lands
+	}
+	return b.maxBandwidth
+}
+
+func (b *BBRSender) targetCwnd(gain float64) qcongestion.ByteCount {
+	bdp := qcongestion.ByteCount(float64(b.bandwidthEstimate()) * b.minRTT().Seconds() * gain)
+	if bdp < b.minCwnd {
+		return b.minCwnd
+	}
+	return bdp
+}
+
+// TimeUntilSend returns the zero time (send now) while the congestion window
+// has room, so packets within the window are not paced; once the window is
+// full it returns one datagram's worth of time at bandwidthEstimate *
+// pacingGain from now.
+func (b *BBRSender) TimeUntilSend(bytesInFlight qcongestion.ByteCount) time.Time {
+	if b.CanSend(bytesInFlight) {
+		return time.Time{}
+	}
+	rate := float64(b.bandwidthEstimate()) * b.pacingGain
+	if rate <= 0 {
+		return time.Time{}
+	}
+	delay := time.Duration(float64(b.maxDatagramSize) / rate * float64(time.Second))
+	return time.Now().Add(delay)
+}
+
+func (b *BBRSender) HasPacingBudget() bool {
+	return true
+}
+
+func (b *BBRSender) CanSend(bytesInFlight qcongestion.ByteCount) bool {
+	return bytesInFlight < b.GetCongestionWindow()
+}
+
+func (b *BBRSender) MaybeExitSlowStart() {
+	// BBR doesn't have a separate slow-start exit signal; Startup exits via
+	// maybeExitStartupOrDrain once the bandwidth filter stops growing.
+}
+
+func (b *BBRSender) OnPacketSent(sentTime time.Time, bytesInFlight qcongestion.ByteCount,
+	packetNumber qcongestion.PacketNumber, bytes qcongestion.ByteCount, isRetransmittable bool) {
+	if b.cycleStart.IsZero() {
+		b.cycleStart = sentTime
+	}
+	b.totalSent += bytes
+}
+
+func (b *BBRSender) OnPacketAcked(number qcongestion.PacketNumber, ackedBytes qcongestion.ByteCount,
+	priorInFlight qcongestion.ByteCount, eventTime time.Time) {
+	b.cumulativeAcked += ackedBytes
+	b.sampleBandwidth(ackedBytes, eventTime)
+	prevRound := b.roundTripCount
+	b.maybeEndRound()
+	roundEnded := b.roundTripCount != prevRound
+	b.updateMinRTT(eventTime)
+
+	switch b.mode {
+	case bbrStartup:
+		if roundEnded {
+			b.maybeExitStartup()
+		}
+	case bbrDrain:
+		// Gate on bytes actually in flight vs. the BDP-sized target, not on
+		// b.cwnd: cwnd was last set to targetCwnd(cwndGain) with cwndGain
+		// still at bbrHighGain during Drain, so comparing it against
+		// targetCwnd(1) is almost always true and this would never fire.
+		if priorInFlight <= b.targetCwnd(1) {
+			b.enterProbeBW(eventTime)
+		}
+	case bbrProbeBW:
+		b.advanceCycle(eventTime)
+	case bbrProbeRTT:
+		b.maybeExitProbeRTT(eventTime)
+	}
+	b.maybeEnterProbeRTT(eventTime)
+
+	b.cwnd = b.targetCwnd(b.cwndGain)
+}
+
+func (b *BBRSender) sampleBandwidth(ackedBytes qcongestion.ByteCount, eventTime time.Time) {
+	if b.lastSampleTime.IsZero() {
+		b.lastSampleTime = eventTime
+		b.lastSampleAcked = b.cumulativeAcked
+		return
+	}
+	elapsed := eventTime.Sub(b.lastSampleTime)
+	if elapsed < time.Millisecond {
+		return // avoid dividing by ~0 on back-to-back acks in the same batch
+	}
+	delivered := b.cumulativeAcked - b.lastSampleAcked
+	rate := qcongestion.ByteCount(float64(delivered) / elapsed.Seconds())
+	b.lastSampleTime = eventTime
+	b.lastSampleAcked = b.cumulativeAcked
+
+	b.bwSamples = append(b.bwSamples, bandwidthSample{bandwidth: rate, round: b.roundTripCount})
+	// Evict samples that have aged out of the window, then take the max of
+	// what's left: the windowed-max filter BBR uses so one lucky burst of
+	// acks doesn't get immediately forgotten, but a stale high sample from
+	// several round trips ago doesn't linger either.
+	cutoff := int64(b.roundTripCount) - bandwidthWindowRTTs
+	kept := b.bwSamples[:0]
+	var max qcongestion.ByteCount
+	for _, s := range b.bwSamples {
+		if int64(s.round) < cutoff {
+			continue
+		}
+		kept = append(kept, s)
+		if s.bandwidth > max {
+			max = s.bandwidth
+		}
+	}
+	b.bwSamples = kept
+	if max > 0 {
+		b.maxBandwidth = max
+	}
+}
+
+// maybeEndRound ends the current round trip once every byte that was in
+// flight when the round started has been acked, then marks the next round's
+// end point as everything sent since. Ending the round on every single ACK
+// (as a naive cumulativeAcked-vs-itself check would) corrupts both
+// startupFullBWRounds and the bandwidthWindowRTTs eviction window, since
+// both are counted in round trips, not acks.
+func (b *BBRSender) maybeEndRound() {
+	if b.cumulativeAcked >= b.roundTripEndedAt {
+		b.roundTripCount++
+		b.roundTripEndedAt = b.totalSent
+	}
+}
+
+// updateMinRTT refreshes minRTTStamp whenever rttStats reports a new,
+// smaller MinRTT(), so maybeEnterProbeRTT knows how long the current
+// estimate has gone untested.
+func (b *BBRSender) updateMinRTT(now time.Time) {
+	rtt := b.minRTT()
+	if rtt <= 0 {
+		return
+	}
+	if b.minRTTStamp.IsZero() || rtt < b.lastMinRTT {
+		b.lastMinRTT = rtt
+		b.minRTTStamp = now
+	}
+}
+
+func (b *BBRSender) maybeExitStartup() {
+	if b.maxBandwidth > qcongestion.ByteCount(float64(b.fullBandwidth)*startupFullBWThreshold) {
+		b.fullBandwidth = b.maxBandwidth
+		b.fullBandwidthRounds = 0
+		return
+	}
+	b.fullBandwidthRounds++
+	if b.fullBandwidthRounds >= startupFullBWRounds {
+		b.fullBandwidthReached = true
+		b.mode = bbrDrain
+		b.pacingGain = bbrDrainGain
+		b.cwndGain = bbrHighGain
+	}
+}
+
+func (b *BBRSender) enterProbeBW(now time.Time) {
+	b.mode = bbrProbeBW
+	b.cwndGain = 2
+	b.cycleIndex = 0
+	b.cycleStart = now
+	b.pacingGain = probeBWGainCycle[b.cycleIndex]
+}
+
+func (b *BBRSender) advanceCycle(now time.Time) {
+	rtt := b.minRTT()
+	if rtt > 0 && now.Sub(b.cycleStart) >= rtt {
+		b.cycleIndex = (b.cycleIndex + 1) % len(probeBWGainCycle)
+		b.cycleStart = now
+		b.pacingGain = probeBWGainCycle[b.cycleIndex]
+	}
+}
+
+func (b *BBRSender) maybeEnterProbeRTT(now time.Time) {
+	if b.mode == bbrProbeRTT || b.minRTTStamp.IsZero() {
+		return
+	}
+	if now.Sub(b.minRTTStamp) < minRTTExpiry {
+		return
+	}
+	b.mode = bbrProbeRTT
+	b.pacingGain = 1
+	b.cwndGain = 1
+	b.probeRTTStart = now
+	b.probeRTTEndsAt = time.Time{}
+}
+
+func (b *BBRSender) maybeExitProbeRTT(now time.Time) {
+	// GetCongestionWindow holds the window at minCwnd while in ProbeRTT;
+	// once it's had probeRTTDuration down there, restart the min-RTT expiry
+	// clock and resume bandwidth probing.
+	if b.probeRTTEndsAt.IsZero() && now.Sub(b.probeRTTStart) >= probeRTTDuration {
+		b.probeRTTEndsAt = now
+	}
+	if !b.probeRTTEndsAt.IsZero() {
+		b.minRTTStamp = now
+		if b.fullBandwidthReached {
+			b.enterProbeBW(now)
+		} else {
+			b.mode = bbrStartup
+			b.pacingGain = bbrHighGain
+			b.cwndGain = bbrHighGain
+		}
+	}
+}
+
+func (b *BBRSender) OnPacketLost(number qcongestion.PacketNumber, lostBytes qcongestion.ByteCount, priorInFlight qcongestion.ByteCount) {
+	// Unlike Cubic/Reno, BBR doesn't treat loss alone as a congestion
+	// signal: the window is already governed by the bandwidth/RTT model, so
+	// there's nothing to shrink here beyond what ProbeBW's Drain phase
+	// (pacingGain 0.75) already does on a schedule.
+}
+
+func (b *BBRSender) OnRetransmissionTimeout(packetsRetransmitted bool) {
+	if packetsRetransmitted {
+		b.cwnd = b.minCwnd
+	}
+}
+
+func (b *BBRSender) InSlowStart() bool {
+	return b.mode == bbrStartup
+}
+
+func (b *BBRSender) InRecovery() bool {
+	return false
+}
+
+func (b *BBRSender) GetCongestionWindow() qcongestion.ByteCount {
+	if b.mode == bbrProbeRTT {
+		return b.minCwnd
+	}
+	return b.cwnd
+}
@@ -0,0 +1,53 @@
+package congestion
+
+import (
+	"testing"
+	"time"
+
+	qcongestion "github.com/lucas-clemente/quic-go/congestion"
+)
+
+func TestBBRMinRTTAfterTwoAcks(t *testing.T) {
+	rttStats := qcongestion.NewRTTStats()
+	b := NewBBRSender(1<<20, rttStats)
+
+	now := time.Now()
+	rttStats.UpdateRTT(50*time.Millisecond, 0, now)
+	b.updateMinRTT(now)
+
+	now = now.Add(100 * time.Millisecond)
+	rttStats.UpdateRTT(50*time.Millisecond, 0, now)
+	b.updateMinRTT(now)
+
+	if got := b.minRTT(); got != 50*time.Millisecond {
+		t.Fatalf("minRTT() = %v, want 50ms", got)
+	}
+	if b.minRTTStamp.IsZero() {
+		t.Fatal("minRTTStamp was never set")
+	}
+}
+
+func TestBBRMaybeEndRoundCountsRoundsNotAcks(t *testing.T) {
+	b := NewBBRSender(1<<20, qcongestion.NewRTTStats())
+	b.totalSent = 1000
+	b.roundTripEndedAt = 1000
+
+	b.cumulativeAcked = 500
+	b.maybeEndRound()
+	if b.roundTripCount != 0 {
+		t.Fatalf("roundTripCount = %d after a partial ack, want 0", b.roundTripCount)
+	}
+
+	b.cumulativeAcked = 1000
+	b.totalSent = 1500
+	b.maybeEndRound()
+	if b.roundTripCount != 1 {
+		t.Fatalf("roundTripCount = %d once the round's bytes are all acked, want 1", b.roundTripCount)
+	}
+
+	b.cumulativeAcked = 1200
+	b.maybeEndRound()
+	if b.roundTripCount != 1 {
+		t.Fatalf("roundTripCount = %d after a partial second round, want 1", b.roundTripCount)
+	}
+}
@@ -0,0 +1,48 @@
+package congestion
+
+import (
+	"fmt"
+
+	qcongestion "github.com/lucas-clemente/quic-go/congestion"
+)
+
+// initialMaxDatagramSize mirrors quic-go's own default. It sizes the
+// Cubic/Reno senders below and is BBR's starting datagram size and minimum
+// window unit; Brutal sizes itself off refBPS instead.
+const initialMaxDatagramSize qcongestion.ByteCount = 1252
+
+// Factory builds a CongestionControl for a connection, given the reference
+// bytes/sec the user configured (ignored by senders, like Cubic and Reno,
+// that size their window off ACKs and loss rather than a target rate).
+type Factory func(refBPS uint64) qcongestion.CongestionControl
+
+// Registry maps a congestion-control name, as given in the client's
+// `congestion` config key, to the Factory that builds it. Brutal stays the
+// default: it's the only one that can hit a fixed target rate on a link
+// with non-congestion loss, which is the common case Hysteria is built for.
+// BBR and Cubic/Reno are here for users on a fairly-shared link who'd
+// rather not blast a fixed rate regardless of what else is using it.
+var Registry = map[string]Factory{
+	"brutal": func(refBPS uint64) qcongestion.CongestionControl {
+		return NewBrutalSender(qcongestion.ByteCount(refBPS))
+	},
+	"bbr": func(refBPS uint64) qcongestion.CongestionControl {
+		return NewBBRSender(qcongestion.ByteCount(refBPS), qcongestion.NewRTTStats())
+	},
+	"cubic": func(refBPS uint64) qcongestion.CongestionControl {
+		return qcongestion.NewCubicSender(qcongestion.DefaultClock{}, qcongestion.NewRTTStats(), false, initialMaxDatagramSize)
+	},
+	"reno": func(refBPS uint64) qcongestion.CongestionControl {
+		return qcongestion.NewCubicSender(qcongestion.DefaultClock{}, qcongestion.NewRTTStats(), true, initialMaxDatagramSize)
+	},
+}
+
+// Lookup returns the Factory registered under name, or an error if name
+// isn't a recognized congestion-control algorithm.
+func Lookup(name string) (Factory, error) {
+	f, ok := Registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown congestion control %q", name)
+	}
+	return f, nil
+}
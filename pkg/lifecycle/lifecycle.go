@@ -0,0 +1,282 @@
+// Package lifecycle implements graceful restart and shutdown for the
+// Hysteria client: SIGHUP/SIGUSR2 hands listening file descriptors to a
+// freshly-exec'd child and drains in-flight connections on the old process,
+// SIGTERM/SIGINT drains and exits, SIGQUIT exits immediately.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Closer is implemented by local listeners that can stop accepting new
+// connections and wait for in-flight ones to finish.
+type Closer interface {
+	Shutdown(ctx context.Context) error
+}
+
+type namedFile struct {
+	name string
+	file *os.File
+}
+
+// Manager tracks every listening socket and local server the client has
+// opened, and coordinates reload/shutdown across them on receipt of a signal.
+type Manager struct {
+	// DrainTimeout bounds how long Shutdown waits for in-flight connections
+	// before giving up.
+	DrainTimeout time.Duration
+	// OnReload, if set, is called right before a child process is spawned.
+	OnReload func()
+	// OnShutdown, if set, is called right before draining begins on a
+	// terminal SIGTERM/SIGINT.
+	OnShutdown func()
+
+	mu            sync.Mutex
+	files         []namedFile
+	closers       []Closer
+	closersByName map[string]Closer
+	active        map[string]*int64
+}
+
+// NewManager creates a Manager with the given drain timeout for graceful
+// shutdown (0 means wait forever).
+func NewManager(drainTimeout time.Duration) *Manager {
+	return &Manager{
+		DrainTimeout:  drainTimeout,
+		active:        make(map[string]*int64),
+		closersByName: make(map[string]Closer),
+	}
+}
+
+// filer is implemented by the net.Listener/net.PacketConn types that can
+// hand back the underlying *os.File for passing to a child process.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// RegisterListener records l under name so it can be handed off to a child
+// process on reload, and associates closer so it can be drained on shutdown.
+// l must implement File() (*os.File, error), as *net.TCPListener does.
+func (m *Manager) RegisterListener(name string, l net.Listener, closer Closer) error {
+	f, ok := l.(filer)
+	if !ok {
+		return fmt.Errorf("listener %q does not support fd handoff (%T)", name, l)
+	}
+	return m.register(name, f, closer)
+}
+
+// RegisterPacketConn is RegisterListener for the packet-oriented (UDP)
+// listeners. pc must implement File() (*os.File, error), as *net.UDPConn does.
+func (m *Manager) RegisterPacketConn(name string, pc net.PacketConn, closer Closer) error {
+	f, ok := pc.(filer)
+	if !ok {
+		return fmt.Errorf("packet conn %q does not support fd handoff (%T)", name, pc)
+	}
+	return m.register(name, f, closer)
+}
+
+// RegisterCloser records closer under name for draining on shutdown, without
+// any fd handoff on reload (e.g. for a TUN device whose fd isn't reusable
+// across a full interface re-creation). It still participates in Run's drain
+// phase on SIGTERM/SIGINT/SIGHUP/SIGUSR2.
+func (m *Manager) RegisterCloser(name string, closer Closer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closers = append(m.closers, closer)
+	m.closersByName[name] = closer
+	active := int64(0)
+	m.active[name] = &active
+}
+
+// RegisterFile records file under name so it's handed off to a child process
+// on reload by fd number, same as RegisterListener/RegisterPacketConn, for
+// callers that already hold a raw *os.File (e.g. a TUN device fd) rather than
+// a net.Listener/net.PacketConn.
+func (m *Manager) RegisterFile(name string, file *os.File, closer Closer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files = append(m.files, namedFile{name: name, file: file})
+	if closer != nil {
+		m.closers = append(m.closers, closer)
+		m.closersByName[name] = closer
+	}
+	active := int64(0)
+	m.active[name] = &active
+}
+
+func (m *Manager) register(name string, f filer, closer Closer) error {
+	file, err := f.File()
+	if err != nil {
+		return fmt.Errorf("get file for listener %q: %w", name, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files = append(m.files, namedFile{name: name, file: file})
+	if closer != nil {
+		m.closers = append(m.closers, closer)
+		m.closersByName[name] = closer
+	}
+	active := int64(0)
+	m.active[name] = &active
+	return nil
+}
+
+// SetEnabled disables or re-enables the named registered listener. Disabling
+// calls Shutdown on its Closer, which stops it accepting new connections;
+// there is no supported way to resume accepting on the same listener
+// afterwards short of a reload, so enabling returns an error instead of
+// silently doing nothing.
+func (m *Manager) SetEnabled(name string, enabled bool) error {
+	if enabled {
+		return fmt.Errorf("listener %q cannot be re-enabled without a reload", name)
+	}
+	m.mu.Lock()
+	closer, ok := m.closersByName[name]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown listener %q", name)
+	}
+	return closer.Shutdown(context.Background())
+}
+
+// ActiveCounter returns an atomic counter a listener should increment when it
+// accepts a connection and decrement when that connection closes, to track
+// how many sessions are in flight per listener. Manager itself does not read
+// or log these counters.
+func (m *Manager) ActiveCounter(name string) *int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.active[name]; ok {
+		return c
+	}
+	active := int64(0)
+	m.active[name] = &active
+	return &active
+}
+
+// Run installs signal handlers and blocks until the process should exit. It
+// never returns on a clean shutdown or reload; it terminates the process
+// itself (os.Exit) once draining completes.
+func (m *Manager) Run() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGUSR2, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	for sig := range sigCh {
+		switch sig {
+		case syscall.SIGHUP, syscall.SIGUSR2:
+			logrus.WithField("signal", sig).Info("Reloading: handing off listeners to a new process")
+			if m.OnReload != nil {
+				m.OnReload()
+			}
+			if err := m.reexec(); err != nil {
+				logrus.WithField("error", err).Error("Reload failed, continuing with the current process")
+				continue
+			}
+			m.drain("reload")
+			os.Exit(0)
+		case syscall.SIGTERM, syscall.SIGINT:
+			logrus.WithField("signal", sig).Info("Shutting down gracefully")
+			if m.OnShutdown != nil {
+				m.OnShutdown()
+			}
+			m.drain("shutdown")
+			os.Exit(0)
+		case syscall.SIGQUIT:
+			logrus.WithField("signal", sig).Warn("Force exit")
+			os.Exit(1)
+		}
+	}
+}
+
+// reexec forks a child process that inherits every registered file
+// descriptor via LISTEN_FDS/LISTEN_FDNAMES (systemd socket activation
+// convention), so it can pick the sockets straight back up.
+func (m *Manager) reexec() error {
+	m.mu.Lock()
+	files := append([]namedFile(nil), m.files...)
+	m.mu.Unlock()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("find current executable: %w", err)
+	}
+
+	extraFiles := make([]*os.File, len(files))
+	names := make([]string, len(files))
+	for i, nf := range files {
+		extraFiles[i] = nf.file
+		names[i] = nf.name
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = extraFiles
+	// LISTEN_PID isn't set here: the child's pid doesn't exist until Start
+	// returns, by which point its env is already fixed, so there's no value
+	// we could put in cmd.Env that's both known now and correct for the
+	// child. Its own init() normalizes LISTEN_PID to its real pid instead.
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("LISTEN_FDS=%d", len(extraFiles)),
+		fmt.Sprintf("LISTEN_FDNAMES=%s", joinNames(names)),
+	)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start child process: %w", err)
+	}
+	logrus.WithField("pid", cmd.Process.Pid).Info("New process started, draining current one")
+	return nil
+}
+
+// drain stops accepting new connections on every registered listener and
+// waits (up to DrainTimeout, if set) for in-flight ones to finish.
+func (m *Manager) drain(reason string) {
+	ctx := context.Background()
+	if m.DrainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.DrainTimeout)
+		defer cancel()
+	}
+	m.mu.Lock()
+	closers := append([]Closer(nil), m.closers...)
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range closers {
+		wg.Add(1)
+		go func(c Closer) {
+			defer wg.Done()
+			if err := c.Shutdown(ctx); err != nil {
+				logrus.WithField("error", err).Warn("Listener did not shut down cleanly")
+			}
+		}(c)
+	}
+	wg.Wait()
+	logrus.WithField("reason", reason).Info("All listeners drained")
+}
+
+func joinNames(names []string) string {
+	out := ""
+	for i, n := range names {
+		if i > 0 {
+			out += ":"
+		}
+		out += n
+	}
+	return out
+}
+
+// IncActive adjusts the in-flight connection count for the named listener.
+func IncActive(counter *int64, delta int64) {
+	atomic.AddInt64(counter, delta)
+}
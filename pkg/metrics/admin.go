@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Status is the payload returned by the admin API's /api/status endpoint.
+type Status struct {
+	Server    string  `json:"server"`
+	Connected bool    `json:"connected"`
+	QUICRTT   float64 `json:"quic_rtt_seconds"`
+}
+
+// AdminServer exposes a Prometheus /metrics endpoint alongside a small JSON
+// control API for inspecting and tweaking a running client at runtime.
+type AdminServer struct {
+	mux *http.ServeMux
+
+	// StatusFunc, when set, is called to produce the current client status.
+	StatusFunc func() Status
+	// ReloadACLFunc, when set, is called to reload the ACL file in place.
+	ReloadACLFunc func() error
+	// SetListenerEnabledFunc, when set, is called to enable/disable a named local listener.
+	SetListenerEnabledFunc func(listener string, enabled bool) error
+}
+
+// NewAdminServer creates an admin HTTP server. Call ListenAndServe to start it.
+func NewAdminServer() *AdminServer {
+	s := &AdminServer{mux: http.NewServeMux()}
+	s.mux.Handle("/metrics", promhttp.Handler())
+	s.mux.HandleFunc("/api/status", s.handleStatus)
+	s.mux.HandleFunc("/api/acl/reload", s.handleACLReload)
+	s.mux.HandleFunc("/api/listener", s.handleListener)
+	return s
+}
+
+func (s *AdminServer) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s.mux)
+}
+
+func (s *AdminServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if s.StatusFunc == nil {
+		http.Error(w, "status not available", http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.StatusFunc())
+}
+
+func (s *AdminServer) handleACLReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.ReloadACLFunc == nil {
+		http.Error(w, "ACL reload not available", http.StatusNotImplemented)
+		return
+	}
+	if err := s.ReloadACLFunc(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *AdminServer) handleListener(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.SetListenerEnabledFunc == nil {
+		http.Error(w, "listener control not available", http.StatusNotImplemented)
+		return
+	}
+	var req struct {
+		Listener string `json:"listener"`
+		Enabled  bool   `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := s.SetListenerEnabledFunc(req.Listener, req.Enabled); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Listener names used as the "listener" label value across the metrics below.
+const (
+	ListenerSOCKS5 = "socks5"
+	ListenerHTTP   = "http"
+	ListenerTUN    = "tun"
+	ListenerTCP    = "tcp_relay"
+	ListenerUDP    = "udp_relay"
+	ListenerTProxy = "tproxy"
+	// ListenerAggregate is used for metrics that can only be measured in
+	// aggregate across all listeners, such as bytes sent, which is only
+	// observable from the shared QUIC connection's congestion controller.
+	ListenerAggregate = "aggregate"
+)
+
+var (
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "hysteria",
+		Subsystem: "client",
+		Name:      "requests_total",
+		Help:      "Total number of requests handled, by listener",
+	}, []string{"listener"})
+
+	BytesTransferred = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "hysteria",
+		Subsystem: "client",
+		Name:      "bytes_total",
+		Help:      "Total bytes transferred, by listener and direction",
+	}, []string{"listener", "direction"})
+
+	ActiveSessions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "hysteria",
+		Subsystem: "client",
+		Name:      "active_sessions",
+		Help:      "Number of currently active sessions, by listener",
+	}, []string{"listener"})
+
+	ACLActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "hysteria",
+		Subsystem: "client",
+		Name:      "acl_actions_total",
+		Help:      "Total number of ACL decisions, by action",
+	}, []string{"action"})
+
+	QUICRTT = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "hysteria",
+		Subsystem: "client",
+		Name:      "quic_rtt_seconds",
+		Help:      "Latest smoothed QUIC round-trip time",
+	})
+
+	QUICCongestionWindow = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "hysteria",
+		Subsystem: "client",
+		Name:      "quic_congestion_window_bytes",
+		Help:      "Current QUIC congestion window, as reported by the active congestion controller",
+	})
+
+	Reconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "hysteria",
+		Subsystem: "client",
+		Name:      "reconnects_total",
+		Help:      "Total number of times the client has reconnected to the server",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(Requests, BytesTransferred, ActiveSessions, ACLActions,
+		QUICRTT, QUICCongestionWindow, Reconnects)
+}
+
+// AddBytes records n bytes transferred for listener in the given direction ("rx" or "tx").
+func AddBytes(listener, direction string, n int) {
+	if n <= 0 {
+		return
+	}
+	BytesTransferred.WithLabelValues(listener, direction).Add(float64(n))
+}
+
+// IncActiveSessions adjusts the active session gauge for listener by delta (+1 on open, -1 on close).
+func IncActiveSessions(listener string, delta float64) {
+	ActiveSessions.WithLabelValues(listener).Add(delta)
+}
@@ -0,0 +1,410 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// frameHeaderLen is the size of the header this package prepends to every
+// multiplexed message it sends inside a WebSocket binary frame: 1 byte of
+// flags, a 4 byte stream id, and a 4 byte payload length.
+const frameHeaderLen = 9
+
+const (
+	wsFlagOpen byte = 1 << iota
+	wsFlagClose
+)
+
+// Session multiplexes any number of Streams over a single underlying
+// WebSocket connection, mirroring the subset of a QUIC session that
+// core.NewClientWithTransport needs to run the Hysteria protocol on top of.
+type Session interface {
+	OpenStream() (Stream, error)
+	AcceptStream(ctx context.Context) (Stream, error)
+	Close() error
+}
+
+// Stream is one multiplexed, ordered, reliable byte stream within a Session.
+type Stream interface {
+	net.Conn
+}
+
+// WSTransport reaches the Hysteria server over a WebSocket-over-HTTPS
+// connection instead of QUIC, for networks that block or heavily throttle
+// UDP. It's passed to core.NewClientWithTransport in place of the usual
+// QUIC dial parameters.
+type WSTransport struct {
+	Host      string
+	Path      string
+	TLSConfig *tls.Config
+}
+
+// NewWSTransport creates a WSTransport that connects to host (used as both
+// the TCP dial address and the Host header) at path, over TLS per tlsConfig.
+func NewWSTransport(host, path string, tlsConfig *tls.Config) *WSTransport {
+	return &WSTransport{Host: host, Path: path, TLSConfig: tlsConfig}
+}
+
+// Dial opens a new WebSocket connection to the server and returns a Session
+// that multiplexes Hysteria streams over it.
+func (t *WSTransport) Dial(ctx context.Context) (Session, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", t.Host)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", t.Host, err)
+	}
+	tlsConn := tls.Client(conn, t.TLSConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake: %w", err)
+	}
+	br, err := wsClientHandshake(tlsConn, t.Host, t.Path)
+	if err != nil {
+		tlsConn.Close()
+		return nil, fmt.Errorf("WebSocket handshake: %w", err)
+	}
+	return newWSSession(tlsConn, br), nil
+}
+
+// wsClientHandshake performs the RFC 6455 client handshake over conn: an
+// HTTP/1.1 GET with the Upgrade/Connection/Sec-WebSocket-* headers, then
+// verifies the server's 101 response and Sec-WebSocket-Accept. It returns the
+// bufio.Reader the response was read through, since a server that coalesces
+// the 101 response with the first WS frame in one TLS record can leave
+// already-received frame bytes buffered in it; the caller must keep reading
+// from this reader rather than the raw conn to avoid losing them.
+func wsClientHandshake(conn net.Conn, host, path string) (*bufio.Reader, error) {
+	keyBytes := make([]byte, 16)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+
+	req := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + host + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+		return nil, errors.New("missing Upgrade: websocket header")
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, errors.New("invalid Sec-WebSocket-Accept")
+	}
+	return br, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// writeWSFrame sends payload as a single unfragmented, masked WebSocket
+// binary frame, per RFC 6455 (client-to-server frames must be masked).
+func writeWSFrame(w io.Writer, payload []byte) error {
+	length := len(payload)
+	var header []byte
+	switch {
+	case length <= 125:
+		header = []byte{0x82, 0x80 | byte(length)}
+	case length <= 0xFFFF:
+		header = []byte{0x82, 0x80 | 126, byte(length >> 8), byte(length)}
+	default:
+		header = make([]byte, 10)
+		header[0] = 0x82
+		header[1] = 0x80 | 127
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	header = append(header, mask[:]...)
+	masked := make([]byte, length)
+	for i, b := range payload {
+		masked[i] = b ^ mask[i%4]
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(masked)
+	return err
+}
+
+// readWSFrame reads a single WebSocket frame from r and returns its opcode
+// and (unmasked) payload. Fragmented frames are not supported, since this
+// package only ever sends whole frames on the other end.
+func readWSFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	opcode = first & 0x0F
+	second, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	masked := second&0x80 != 0
+	length := uint64(second & 0x7F)
+	switch length {
+	case 126:
+		var buf [2]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(buf[:]))
+	case 127:
+		var buf [8]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(buf[:])
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
+
+// wsSession is the Session implementation backing WSTransport: a read loop
+// demultiplexes incoming frames by stream id, and writes are serialized
+// behind writeMu since they all share one underlying WebSocket connection.
+type wsSession struct {
+	conn    net.Conn
+	br      *bufio.Reader
+	writeMu sync.Mutex
+
+	mu sync.Mutex
+	// nextID allocates locally-opened stream ids. The client always opens
+	// even ids (0, 2, 4, ...) and a peer opening its own streams is expected
+	// to use odd ones, the same even/odd split HTTP/2 uses to keep the two
+	// directions' id spaces from colliding in s.streams.
+	nextID   uint32
+	streams  map[uint32]*wsStream
+	accepted chan *wsStream
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newWSSession wraps conn in a Session, reading through br rather than a
+// fresh bufio.Reader so any bytes wsClientHandshake already buffered past the
+// handshake response (the start of the server's first WS frame) aren't lost.
+func newWSSession(conn net.Conn, br *bufio.Reader) *wsSession {
+	s := &wsSession{
+		conn:     conn,
+		br:       br,
+		streams:  make(map[uint32]*wsStream),
+		accepted: make(chan *wsStream, 16),
+		closed:   make(chan struct{}),
+	}
+	go s.readLoop()
+	return s
+}
+
+func (s *wsSession) OpenStream() (Stream, error) {
+	s.mu.Lock()
+	id := s.nextID
+	s.nextID += 2
+	st := newWSStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+	if err := s.writeFrame(wsFlagOpen, id, nil); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *wsSession) AcceptStream(ctx context.Context) (Stream, error) {
+	select {
+	case st, ok := <-s.accepted:
+		if !ok {
+			return nil, errors.New("transport: session closed")
+		}
+		return st, nil
+	case <-s.closed:
+		return nil, errors.New("transport: session closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *wsSession) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.mu.Lock()
+		for _, st := range s.streams {
+			st.closeLocal()
+		}
+		s.mu.Unlock()
+	})
+	return s.conn.Close()
+}
+
+func (s *wsSession) writeFrame(flags byte, id uint32, payload []byte) error {
+	msg := make([]byte, frameHeaderLen+len(payload))
+	msg[0] = flags
+	binary.BigEndian.PutUint32(msg[1:5], id)
+	binary.BigEndian.PutUint32(msg[5:9], uint32(len(payload)))
+	copy(msg[9:], payload)
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return writeWSFrame(s.conn, msg)
+}
+
+func (s *wsSession) readLoop() {
+	defer s.Close()
+	for {
+		opcode, data, err := readWSFrame(s.br)
+		if err != nil {
+			return
+		}
+		if opcode != 0x2 || len(data) < frameHeaderLen {
+			continue // ignore control frames (ping/pong/close); the next read will error once the peer hangs up
+		}
+		flags := data[0]
+		id := binary.BigEndian.Uint32(data[1:5])
+		length := binary.BigEndian.Uint32(data[5:9])
+		payload := data[9:]
+		if uint32(len(payload)) != length {
+			return
+		}
+		s.mu.Lock()
+		st, ok := s.streams[id]
+		if !ok && flags&wsFlagOpen != 0 {
+			st = newWSStream(s, id)
+			s.streams[id] = st
+		}
+		s.mu.Unlock()
+		if st == nil {
+			continue
+		}
+		if flags&wsFlagOpen != 0 {
+			select {
+			case s.accepted <- st:
+			default:
+			}
+		}
+		if len(payload) > 0 {
+			st.deliver(payload)
+		}
+		if flags&wsFlagClose != 0 {
+			st.closeRemote()
+		}
+	}
+}
+
+// wsStream is one multiplexed stream: writes go straight to the session as
+// individual frames, reads come off a buffer fed by the session's read loop.
+type wsStream struct {
+	session *wsSession
+	id      uint32
+
+	mu           sync.Mutex
+	cond         *sync.Cond
+	readBuf      bytes.Buffer
+	remoteClosed bool
+	localClosed  bool
+}
+
+func newWSStream(s *wsSession, id uint32) *wsStream {
+	st := &wsStream{session: s, id: id}
+	st.cond = sync.NewCond(&st.mu)
+	return st
+}
+
+func (st *wsStream) deliver(p []byte) {
+	st.mu.Lock()
+	st.readBuf.Write(p)
+	st.cond.Signal()
+	st.mu.Unlock()
+}
+
+func (st *wsStream) closeRemote() {
+	st.mu.Lock()
+	st.remoteClosed = true
+	st.cond.Signal()
+	st.mu.Unlock()
+}
+
+func (st *wsStream) closeLocal() {
+	st.mu.Lock()
+	st.localClosed = true
+	st.cond.Signal()
+	st.mu.Unlock()
+}
+
+func (st *wsStream) Read(p []byte) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for st.readBuf.Len() == 0 && !st.remoteClosed && !st.localClosed {
+		st.cond.Wait()
+	}
+	if st.readBuf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return st.readBuf.Read(p)
+}
+
+func (st *wsStream) Write(p []byte) (int, error) {
+	if err := st.session.writeFrame(0, st.id, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (st *wsStream) Close() error {
+	st.closeLocal()
+	return st.session.writeFrame(wsFlagClose, st.id, nil)
+}
+
+func (st *wsStream) LocalAddr() net.Addr  { return st.session.conn.LocalAddr() }
+func (st *wsStream) RemoteAddr() net.Addr { return st.session.conn.RemoteAddr() }
+
+// Deadlines aren't supported on a multiplexed stream backed by a shared
+// connection; callers that need a timeout should use a context instead.
+func (st *wsStream) SetDeadline(t time.Time) error      { return nil }
+func (st *wsStream) SetReadDeadline(t time.Time) error  { return nil }
+func (st *wsStream) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,44 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestWriteReadWSFrameRoundTrip(t *testing.T) {
+	payload := []byte("hello hysteria")
+
+	var buf bytes.Buffer
+	if err := writeWSFrame(&buf, payload); err != nil {
+		t.Fatalf("writeWSFrame: %v", err)
+	}
+
+	opcode, got, err := readWSFrame(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("readWSFrame: %v", err)
+	}
+	if opcode != 0x2 {
+		t.Fatalf("opcode = %#x, want 0x2 (binary)", opcode)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("payload = %q, want %q", got, payload)
+	}
+}
+
+func TestWriteReadWSFrameRoundTripLargePayload(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xAB}, 70000) // forces the 64-bit length form
+
+	var buf bytes.Buffer
+	if err := writeWSFrame(&buf, payload); err != nil {
+		t.Fatalf("writeWSFrame: %v", err)
+	}
+
+	_, got, err := readWSFrame(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("readWSFrame: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("payload length = %d, want %d", len(got), len(payload))
+	}
+}